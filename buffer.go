@@ -11,12 +11,23 @@ package mysql
 import (
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
 const defaultBufSize = 4096
 const tinyBufferSize = 64
 
+// smallReadThreshold and prefetchWindowSize drive the opportunistic
+// over-read in fillLocked: for a small packet, a single Read syscall
+// that asks for a much larger window is cheap and frequently picks up
+// the server's next few pipelined packets (e.g. prepared-statement
+// result rows) in the same call, instead of one syscall per packet.
+const (
+	smallReadThreshold = 512
+	prefetchWindowSize = 64 * 1024
+)
+
 // A buffer which is used for both reading and writing.
 // This is possible since communication on each connection is synchronous.
 // In other words, we can't write and read simultaneously on the same connection.
@@ -30,6 +41,44 @@ type buffer struct {
 	idx     int
 	length  int
 	timeout time.Duration
+
+	// compress holds the negotiated compression algorithm, or
+	// compressNone on a connection that didn't request CLIENT_COMPRESS.
+	// When set, read() decompresses frames coming off nc transparently,
+	// so everything above buffer keeps working with plain packets.
+	compress    compressionAlgo
+	compressSeq uint8
+	// compressRem holds the tail of the most recently decompressed
+	// frame that hasn't been consumed by a caller yet.
+	compressRem []byte
+
+	// tracer, when non-nil, wraps fill and skip in spans so a caller
+	// can see how much of a slow query is spent waiting on the socket.
+	tracer BufferTracer
+
+	// maxBufferSize caps how large b.large is allowed to grow before
+	// fillLocked starts borrowing scratch memory from largeBufferPool
+	// instead. Zero means no cap: fillLocked never borrows from the pool
+	// and grows b.buf by plain allocation. Set from
+	// Config.MaxBufferSize / the maxBufferSize DSN option at connection
+	// setup.
+	maxBufferSize int
+	// bufPooled is true when b.buf currently points at memory borrowed
+	// from largeBufferPool rather than b.large or b.safe.
+	bufPooled bool
+	// lastUsed is updated on every successful fill and consulted by
+	// shrinkIdle to decide whether b.large has been idle long enough
+	// to reclaim.
+	lastUsed time.Time
+
+	// mu guards b.buf/b.large/b.bufPooled against the idle shrink timer
+	// started by startShrinkTimer, which runs on its own goroutine so it
+	// can reclaim memory from a connection sitting idle in the pool.
+	// Every other buffer method is documented as single-goroutine-only
+	// (see above) and only needs the lock to stay consistent with
+	// whatever shrinkIdle is doing concurrently; there's no contention
+	// outside of that.
+	mu sync.Mutex
 }
 
 // newBuffer allocates and returns a new buffer.
@@ -43,6 +92,15 @@ func newBuffer(nc net.Conn) buffer {
 	}
 }
 
+// enableCompression switches the buffer into compressed mode after the
+// capability has been negotiated during the handshake. It must not be
+// called once any packets have been read.
+func (b *buffer) enableCompression(algo compressionAlgo) {
+	b.compress = algo
+	b.compressSeq = 0
+	b.compressRem = nil
+}
+
 var scratchBuffer [4096]byte
 
 func (b *buffer) skip(need int) error {
@@ -52,63 +110,162 @@ func (b *buffer) skip(need int) error {
 		return nil
 	}
 
-	need -= b.length
-	b.idx += b.length
-	b.length = 0
-
-	for need > 0 {
-		r := need
-		if r > len(scratchBuffer) {
-			r = len(scratchBuffer)
+	return b.traceOp("skip", func() error {
+		if err := b.armReadDeadline(); err != nil {
+			return err
 		}
-		nn, err := b.read(scratchBuffer[:r])
-		need -= nn
 
-		switch err {
-		case nil:
-			continue
-		case io.EOF:
-			if need == 0 {
-				return nil
+		need -= b.length
+		b.idx += b.length
+		b.length = 0
+
+		for need > 0 {
+			r := need
+			if r > len(scratchBuffer) {
+				r = len(scratchBuffer)
+			}
+			nn, err := b.read(scratchBuffer[:r])
+			need -= nn
+
+			switch err {
+			case nil:
+				continue
+			case io.EOF:
+				if need == 0 {
+					return nil
+				}
+				return io.ErrUnexpectedEOF
+			default:
+				return err
 			}
-			return io.ErrUnexpectedEOF
-		default:
-			return err
 		}
+		return nil
+	})
+}
+
+// armReadDeadline sets a fresh nc.SetReadDeadline covering the whole of
+// the logical read operation about to start (a fillLocked or skip call),
+// rather than being re-armed on every low-level b.read inside their
+// loops. A single fillLocked/skip call can turn into several b.read
+// calls - e.g. fillLocked looping until need bytes have arrived - and
+// those all belong to the same caller-specified timeout budget, so one
+// deadline for the whole operation is both correct and cheaper than one
+// SetReadDeadline per underlying Read. Unlike reusing a deadline left
+// over from a previous, unrelated logical read, this never shortens the
+// window a caller actually gets below b.timeout.
+func (b *buffer) armReadDeadline() error {
+	if b.timeout <= 0 {
+		return nil
 	}
-	return nil
+	return b.nc.SetReadDeadline(time.Now().Add(b.timeout))
 }
 
 func (b *buffer) read(out []byte) (int, error) {
-	if b.timeout > 0 {
-		if err := b.nc.SetReadDeadline(time.Now().Add(b.timeout)); err != nil {
+	if b.compress != compressNone {
+		return b.readCompressed(out)
+	}
+	return b.nc.Read(out)
+}
+
+// readCompressed drains any leftover bytes from the previously
+// decompressed frame before pulling and decompressing the next one off
+// nc. Packets above buffer are never aware that frames on the wire are
+// compressed and possibly coalesced differently than the packets they
+// carry.
+func (b *buffer) readCompressed(out []byte) (int, error) {
+	if len(b.compressRem) == 0 {
+		frame, seq, err := readCompressedFrame(b.nc, b.compress, nil)
+		if err != nil {
 			return 0, err
 		}
+		b.compressSeq = seq + 1
+		b.compressRem = frame
 	}
-	return b.nc.Read(out)
+
+	n := copy(out, b.compressRem)
+	b.compressRem = b.compressRem[n:]
+	return n, nil
 }
 
 // fill reads into the buffer until at least _need_ bytes are in it
 func (b *buffer) fill(need int, safe bool) error {
+	return b.traceOp("fill", func() error { return b.fillLocked(need, safe) })
+}
+
+func (b *buffer) fillLocked(need int, safe bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.armReadDeadline(); err != nil {
+		return err
+	}
+
 	n := b.length
 
 	if safe {
 		copy(b.safe[0:n], b.buf[b.idx:])
+		b.releasePooled()
 		b.buf = b.safe
 	} else {
 		// move existing data to the beginning
 		if n > 0 && b.idx > 0 {
 			copy(b.large[0:n], b.buf[b.idx:])
 		}
+		b.releasePooled()
 		b.buf = b.large
 	}
 
 	// grow buffer if necessary
-	if need > len(b.buf) {
+	growTo := need
+	if !safe && need < smallReadThreshold {
+		// Widen the read window so a single syscall has a chance to
+		// pick up packets the server already pipelined behind this
+		// one, instead of paying one syscall per small packet.
+		window := prefetchWindowSize
+		if b.maxBufferSize > 0 && window > b.maxBufferSize {
+			window = b.maxBufferSize
+		}
+		if window > growTo {
+			growTo = window
+		}
+	}
+
+	if growTo > len(b.buf) {
 		// Round up to the next multiple of the default size
-		newBuf := make([]byte, ((need/defaultBufSize)+1)*defaultBufSize)
-		copy(newBuf, b.buf)
-		b.buf = newBuf
+		newSize := ((growTo / defaultBufSize) + 1) * defaultBufSize
+
+		if !safe && b.maxBufferSize > 0 && newSize > b.maxBufferSize && growTo <= b.maxBufferSize {
+			// The actual request fits under the cap; it was only the
+			// round-up to a defaultBufSize multiple that pushed past
+			// it. Clamp back down rather than falling through to the
+			// pool-borrow path below, otherwise a MaxBufferSize that
+			// lands between defaultBufSize and prefetchWindowSize would
+			// force every small-packet fill through sync.Pool instead
+			// of ever settling into a stable b.large.
+			newSize = b.maxBufferSize
+		}
+
+		if !safe && b.maxBufferSize > 0 && newSize > b.maxBufferSize {
+			// This packet would push b.large past the configured cap.
+			// Borrow scratch memory from the pool instead of growing
+			// b.large, so the connection doesn't retain it once the
+			// packet has been consumed.
+			newBuf := getPooledBuffer(newSize)
+			copy(newBuf, b.buf)
+			b.buf = newBuf
+			b.bufPooled = true
+		} else {
+			newBuf := make([]byte, newSize)
+			copy(newBuf, b.buf)
+			b.buf = newBuf
+			if !safe && growTo != need {
+				// This growth came from the prefetch window rather
+				// than from need itself: keep it as b.large so the
+				// next small packet's fill reuses the wider window
+				// instead of re-growing (and re-copying) every time.
+				b.large = newBuf
+			}
+		}
 	}
 
 	b.idx = 0
@@ -123,11 +280,13 @@ func (b *buffer) fill(need int, safe bool) error {
 				continue
 			}
 			b.length = n
+			b.lastUsed = time.Now()
 			return nil
 
 		case io.EOF:
 			if n >= need {
 				b.length = n
+				b.lastUsed = time.Now()
 				return nil
 			}
 			return io.ErrUnexpectedEOF
@@ -169,11 +328,15 @@ func (b *buffer) readNext(need int, safe bool) ([]byte, error) {
 // Otherwise a bigger buffer is made.
 // Only one buffer (total) can be used at a time.
 func (b *buffer) takeBuffer(length int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.length > 0 {
 		return nil, ErrBusyBuffer
 	}
 
 	// restore original buffer if it's been resized or replaced
+	b.releasePooled()
 	b.buf = b.large
 
 	// test (cheap) general case first
@@ -194,10 +357,14 @@ func (b *buffer) takeBuffer(length int) ([]byte, error) {
 // known to be smaller than defaultBufSize.
 // Only one buffer (total) can be used at a time.
 func (b *buffer) takeSmallBuffer(length int) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.length > 0 {
 		return nil, ErrBusyBuffer
 	}
 	// restore original buffer if it's been resized or replaced
+	b.releasePooled()
 	b.buf = b.large
 	return b.buf[:length], nil
 }
@@ -207,18 +374,29 @@ func (b *buffer) takeSmallBuffer(length int) ([]byte, error) {
 // cap and len of the returned buffer will be equal.
 // Only one buffer (total) can be used at a time.
 func (b *buffer) takeCompleteBuffer() ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.length > 0 {
 		return nil, ErrBusyBuffer
 	}
 	// restore original buffer if it's been resized or replaced
+	b.releasePooled()
 	b.buf = b.large
 	return b.buf, nil
 }
 
 // store stores buf, an updated buffer, if its suitable to do so.
 func (b *buffer) store(buf []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if b.length > 0 {
 		return ErrBusyBuffer
+	} else if b.bufPooled {
+		// b.buf is pool-borrowed memory that releasePooled hands back
+		// to largeBufferPool; keep it rather than swapping in buf.
+		return nil
 	} else if cap(buf) <= maxPacketSize && cap(buf) > cap(b.buf) {
 		b.buf = buf[:cap(buf)]
 	}
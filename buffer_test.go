@@ -0,0 +1,155 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeConn is a minimal net.Conn backed by an in-memory byte slice, used
+// to drive buffer.fill/read without a real socket. chunkSize, when
+// nonzero, caps how many bytes a single Read call hands back, to
+// simulate a server response arriving over several reads instead of one.
+type fakeConn struct {
+	data      []byte
+	chunkSize int
+}
+
+func (c *fakeConn) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	max := len(p)
+	if c.chunkSize > 0 && c.chunkSize < max {
+		max = c.chunkSize
+	}
+	n := copy(p[:max], c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func (c *fakeConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (c *fakeConn) Close() error                       { return nil }
+func (c *fakeConn) LocalAddr() net.Addr                { return nil }
+func (c *fakeConn) RemoteAddr() net.Addr               { return nil }
+func (c *fakeConn) SetDeadline(t time.Time) error      { return nil }
+func (c *fakeConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// BenchmarkFillSmallPipelinedPackets simulates a server that has already
+// pipelined many small (4-byte) packets back to back on the wire, the way
+// prepared-statement result rows typically arrive. It demonstrates the
+// row-throughput win from widening the read window in fillLocked: once
+// the first fill has prefetched the window, consuming the rest of the
+// pipelined packets costs no further Read calls at all.
+func BenchmarkFillSmallPipelinedPackets(b *testing.B) {
+	const packetSize = 4
+	const packetsPerRun = 1000
+
+	payload := make([]byte, packetSize*packetsPerRun)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf := newBuffer(&fakeConn{data: append([]byte(nil), payload...)})
+		for n := 0; n < packetsPerRun; n++ {
+			if buf.length < packetSize {
+				if err := buf.fill(packetSize, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+			buf.idx += packetSize
+			buf.length -= packetSize
+		}
+	}
+}
+
+// TestFillArmsDeadlineOncePerCall checks that a single fillLocked call
+// that needs several underlying b.read calls to satisfy (the server's
+// response trickling in over multiple reads) only sets the deadline
+// once, not once per underlying read.
+func TestFillArmsDeadlineOncePerCall(t *testing.T) {
+	var deadlineSets int
+	conn := &countingDeadlineConn{
+		fakeConn:          fakeConn{data: make([]byte, 256), chunkSize: 8},
+		onSetReadDeadline: func() { deadlineSets++ },
+	}
+
+	b := newBuffer(conn)
+	b.timeout = time.Second
+
+	if err := b.fill(256, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+
+	if deadlineSets != 1 {
+		t.Fatalf("expected a single SetReadDeadline call for one fill spanning several underlying reads, got %d", deadlineSets)
+	}
+}
+
+// TestFillGetsFullTimeoutEvenRightAfterAnotherFill guards against the
+// regression where reusing a deadline left over from an earlier,
+// unrelated fill call could hand a later read far less than the
+// configured timeout. Each fill call must get its own fresh full-length
+// deadline, regardless of how recently the previous one was armed.
+func TestFillGetsFullTimeoutEvenRightAfterAnotherFill(t *testing.T) {
+	var deadlines []time.Time
+	conn := &countingDeadlineConn{
+		fakeConn: fakeConn{data: make([]byte, 16)},
+		onSetReadDeadlineWithTime: func(d time.Time) {
+			deadlines = append(deadlines, d)
+		},
+	}
+
+	b := newBuffer(conn)
+	b.timeout = 30 * time.Second
+
+	if err := b.fill(8, false); err != nil {
+		t.Fatalf("fill 1: %v", err)
+	}
+	b.idx, b.length = 0, 0
+	conn.data = make([]byte, 16)
+
+	// Immediately issue a second, unrelated fill - with the old
+	// cross-call coalescing this would have reused the first fill's
+	// deadline and could've been left with almost no time budget.
+	if err := b.fill(8, false); err != nil {
+		t.Fatalf("fill 2: %v", err)
+	}
+
+	if len(deadlines) != 2 {
+		t.Fatalf("expected a SetReadDeadline call for each fill, got %d", len(deadlines))
+	}
+	for i, d := range deadlines {
+		if got := time.Until(d); got < 29*time.Second {
+			t.Fatalf("fill %d got a deadline only %s out, want close to the full 30s timeout", i, got)
+		}
+	}
+}
+
+type countingDeadlineConn struct {
+	fakeConn
+	onSetReadDeadline         func()
+	onSetReadDeadlineWithTime func(time.Time)
+}
+
+func (c *countingDeadlineConn) SetReadDeadline(t time.Time) error {
+	if c.onSetReadDeadline != nil {
+		c.onSetReadDeadline()
+	}
+	if c.onSetReadDeadlineWithTime != nil {
+		c.onSetReadDeadlineWithTime(t)
+	}
+	return nil
+}
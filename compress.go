@@ -0,0 +1,203 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionAlgo identifies the codec used to deflate/inflate frames on a
+// connection negotiated with CLIENT_COMPRESS (or, for zstd, the compression
+// extension capability). It is stored on buffer once the handshake has
+// settled on an algorithm.
+type compressionAlgo byte
+
+const (
+	compressNone compressionAlgo = iota
+	compressZlib
+	compressZstd
+)
+
+// compressedHeaderSize is the size, in bytes, of the header that precedes
+// every frame on a compressed connection: 3 bytes of compressed length,
+// 1 byte of sequence id, 3 bytes of uncompressed length.
+const compressedHeaderSize = 7
+
+// minCompressLength is the uncompressed payload size at or under which
+// the frame is stored raw (uncompressed length field set to 0) rather than
+// paying the codec overhead for a handful of bytes. This matches the
+// server's own threshold.
+const minCompressLength = 50
+
+// parseCompressionAlgo maps a DSN `compress` value to a compressionAlgo.
+func parseCompressionAlgo(name string) (compressionAlgo, error) {
+	switch name {
+	case "", "none":
+		return compressNone, nil
+	case "zlib":
+		return compressZlib, nil
+	case "zstd":
+		return compressZstd, nil
+	default:
+		return 0, fmt.Errorf("invalid value %q for compress, expected zlib, zstd or none", name)
+	}
+}
+
+func putUint24(b []byte, v int) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// readCompressedFrame reads one compression-header-prefixed frame from r
+// and returns its decompressed payload. dst is reused when it has enough
+// capacity, to avoid an allocation per frame.
+func readCompressedFrame(r io.Reader, algo compressionAlgo, dst []byte) ([]byte, uint8, error) {
+	var hdr [compressedHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, 0, err
+	}
+
+	compLen := uint24(hdr[0:3])
+	seq := hdr[3]
+	uncompLen := uint24(hdr[4:7])
+
+	raw := make([]byte, compLen)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, 0, err
+	}
+
+	// uncompressed length of 0 means the payload, at or below
+	// minCompressLength, was stored as-is.
+	if uncompLen == 0 {
+		return raw, seq, nil
+	}
+
+	if cap(dst) < uncompLen {
+		dst = make([]byte, uncompLen)
+	}
+	dst = dst[:uncompLen]
+
+	switch algo {
+	case compressZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer zr.Close()
+		if _, err := io.ReadFull(zr, dst); err != nil {
+			return nil, 0, err
+		}
+	case compressZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, 0, err
+		}
+		defer zr.Close()
+		if _, err := io.ReadFull(zr, dst); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, fmt.Errorf("mysql: received compressed frame without a negotiated algorithm")
+	}
+
+	return dst, seq, nil
+}
+
+// writeCompressedFrame compresses payload (when it's worth it) and writes
+// it to w wrapped in a compression header, mirroring readCompressedFrame
+// on the write side.
+func writeCompressedFrame(w io.Writer, algo compressionAlgo, seq uint8, payload []byte) error {
+	var body []byte
+	uncompLen := 0
+
+	if len(payload) <= minCompressLength {
+		body = payload
+	} else {
+		var buf bytes.Buffer
+		switch algo {
+		case compressZlib:
+			zw := zlib.NewWriter(&buf)
+			if _, err := zw.Write(payload); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+		case compressZstd:
+			zw, err := zstd.NewWriter(&buf)
+			if err != nil {
+				return err
+			}
+			if _, err := zw.Write(payload); err != nil {
+				return err
+			}
+			if err := zw.Close(); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("mysql: cannot compress frame without a negotiated algorithm")
+		}
+		body = buf.Bytes()
+		uncompLen = len(payload)
+	}
+
+	var hdr [compressedHeaderSize]byte
+	putUint24(hdr[0:3], len(body))
+	hdr[3] = seq
+	putUint24(hdr[4:7], uncompLen)
+
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// writeCompressed writes payload as a single compression-framed packet
+// to the connection, using and then advancing b.compressSeq so the
+// write side stays in step the same way readCompressed keeps
+// b.compressSeq in step on the read side.
+func (b *buffer) writeCompressed(payload []byte) error {
+	if err := writeCompressedFrame(b.nc, b.compress, b.compressSeq, payload); err != nil {
+		return err
+	}
+	b.compressSeq++
+	return nil
+}
+
+// negotiatedCompression reconciles what the DSN asked for (want, decoded
+// by parseCompressionAlgo) against what the server advertised supporting
+// in its handshake packet, and returns the algorithm the connection
+// should actually use. A client that asked for zstd but got a server
+// without zstd support falls back to zlib if the server has that, rather
+// than failing the connection outright.
+func negotiatedCompression(want compressionAlgo, serverSupportsZlib, serverSupportsZstd bool) compressionAlgo {
+	switch want {
+	case compressZstd:
+		if serverSupportsZstd {
+			return compressZstd
+		}
+		fallthrough
+	case compressZlib:
+		if serverSupportsZlib {
+			return compressZlib
+		}
+	}
+	return compressNone
+}
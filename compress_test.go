@@ -0,0 +1,117 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// TestCompressedRoundTrip drives a real pair of connected buffers end to
+// end: one side enables compression and writes via writeCompressed, the
+// other enables compression and reads the plain bytes back out via
+// read(), for both algorithms and for payloads above and below
+// minCompressLength (i.e. both the "actually compressed" and the
+// "stored raw" frame shapes).
+func TestCompressedRoundTrip(t *testing.T) {
+	small := []byte("short")
+	large := bytes.Repeat([]byte("mysql-compress-roundtrip "), 500)
+
+	for _, algo := range []compressionAlgo{compressZlib, compressZstd} {
+		for _, payload := range [][]byte{small, large} {
+			clientConn, serverConn := net.Pipe()
+
+			clientBuf := newBuffer(clientConn)
+			clientBuf.enableCompression(algo)
+
+			serverBuf := newBuffer(serverConn)
+			serverBuf.enableCompression(algo)
+
+			writeErr := make(chan error, 1)
+			go func() {
+				writeErr <- clientBuf.writeCompressed(payload)
+			}()
+
+			got := make([]byte, len(payload))
+			n, err := serverBuf.read(got)
+			if err != nil {
+				t.Fatalf("algo %v payload len %d: read: %v", algo, len(payload), err)
+			}
+			if err := <-writeErr; err != nil {
+				t.Fatalf("algo %v payload len %d: writeCompressed: %v", algo, len(payload), err)
+			}
+			if n != len(payload) {
+				t.Fatalf("algo %v payload len %d: read %d bytes, want %d", algo, len(payload), n, len(payload))
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("algo %v payload len %d: round-tripped bytes don't match", algo, len(payload))
+			}
+
+			clientConn.Close()
+			serverConn.Close()
+		}
+	}
+}
+
+// TestCompressedWriterAdvancesSeq checks that writeCompressed advances
+// b.compressSeq on each call, the write-side counterpart to readCompressed
+// advancing it on the read side.
+func TestCompressedWriterAdvancesSeq(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	clientBuf := newBuffer(clientConn)
+	clientBuf.enableCompression(compressZlib)
+
+	go func() {
+		tmp := make([]byte, 8)
+		serverBuf := newBuffer(serverConn)
+		serverBuf.enableCompression(compressZlib)
+		for i := 0; i < 3; i++ {
+			serverBuf.read(tmp)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		if err := clientBuf.writeCompressed([]byte("payload")); err != nil {
+			t.Fatalf("writeCompressed %d: %v", i, err)
+		}
+	}
+
+	if clientBuf.compressSeq != 3 {
+		t.Fatalf("compressSeq = %d, want 3", clientBuf.compressSeq)
+	}
+}
+
+func TestNegotiatedCompression(t *testing.T) {
+	tests := []struct {
+		name                                   string
+		want                                   compressionAlgo
+		serverSupportsZlib, serverSupportsZstd bool
+		wantAlgo                               compressionAlgo
+	}{
+		{"none requested", compressNone, true, true, compressNone},
+		{"zlib requested and supported", compressZlib, true, false, compressZlib},
+		{"zlib requested, unsupported", compressZlib, false, false, compressNone},
+		{"zstd requested and supported", compressZstd, true, true, compressZstd},
+		{"zstd requested, server only has zlib", compressZstd, true, false, compressZlib},
+		{"zstd requested, server has neither", compressZstd, false, false, compressNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := negotiatedCompression(tt.want, tt.serverSupportsZlib, tt.serverSupportsZstd)
+			if got != tt.wantAlgo {
+				t.Fatalf("negotiatedCompression(%v, %v, %v) = %v, want %v", tt.want, tt.serverSupportsZlib, tt.serverSupportsZstd, got, tt.wantAlgo)
+			}
+		})
+	}
+}
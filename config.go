@@ -0,0 +1,117 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the per-connection options that connect (see conn.go)
+// needs to actually build a buffer: where to dial, how long reads may
+// block, and the knobs this driver slice has grown on top of the buffer
+// layer - compression, the ConnWrapper hook, and buffer memory capping.
+//
+// Addr is taken verbatim, as an opaque dial target; splitting it into
+// user/password/net/dbname the way a full DSN does belongs to this
+// driver's connection-string parsing, not the buffer layer this slice
+// covers.
+type Config struct {
+	Addr        string
+	ReadTimeout time.Duration
+
+	// Compress is the raw DSN value ("zlib", "zstd", "none", or empty).
+	// compressAlgo is the already-validated form connect uses, set by
+	// ParseDSN via parseCompressionAlgo.
+	Compress     string
+	compressAlgo compressionAlgo
+
+	// ConnWrapperName selects a ConnWrapper registered with
+	// RegisterConnWrapper to apply to the dialed net.Conn before connect
+	// builds a buffer around it. Set from the DSN's connwrapper=
+	// parameter.
+	ConnWrapperName string
+
+	// MaxBufferSize caps how large a connection's read buffer is allowed
+	// to grow before large packets are served from a pooled scratch
+	// buffer instead of being retained on b.large. Zero means unbounded.
+	// Set from the DSN's maxBufferSize= parameter.
+	MaxBufferSize int
+
+	// ShrinkIdleBuffer, when nonzero, reclaims a connection's read buffer
+	// back down to defaultBufSize once it has sat idle in the pool for
+	// this long. Zero disables idle shrinking. Set from the DSN's
+	// shrinkIdleBuffer= parameter.
+	ShrinkIdleBuffer time.Duration
+}
+
+// NewConfig returns a Config with the same defaults ParseDSN applies to
+// a DSN with no query parameters.
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// ParseDSN parses a DSN of the form addr[?param=value&...] into a
+// Config. addr is stored as-is on Config.Addr; the recognized query
+// parameters are compress, connwrapper, maxBufferSize and
+// shrinkIdleBuffer.
+func ParseDSN(dsn string) (*Config, error) {
+	cfg := NewConfig()
+
+	addr, rawQuery, _ := strings.Cut(dsn, "?")
+	cfg.Addr = addr
+
+	params, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range params {
+		if err := cfg.applyParam(key, values[len(values)-1]); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// applyParam sets the Config field for one recognized DSN query
+// parameter. Unrecognized parameters are ignored rather than rejected,
+// since the rest of this driver's DSN parameters live outside this
+// buffer-layer slice of the repo.
+func (cfg *Config) applyParam(key, value string) error {
+	switch key {
+	case "compress":
+		algo, err := parseCompressionAlgo(value)
+		if err != nil {
+			return err
+		}
+		cfg.Compress = value
+		cfg.compressAlgo = algo
+
+	case "connwrapper":
+		cfg.ConnWrapperName = value
+
+	case "maxBufferSize":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return &strconv.NumError{Func: "applyParam", Num: value, Err: err}
+		}
+		cfg.MaxBufferSize = n
+
+	case "shrinkIdleBuffer":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		cfg.ShrinkIdleBuffer = d
+	}
+	return nil
+}
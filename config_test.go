@@ -0,0 +1,43 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import "testing"
+
+func TestParseDSNCompress(t *testing.T) {
+	cfg, err := ParseDSN("127.0.0.1:3306?compress=zstd")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	if cfg.Addr != "127.0.0.1:3306" {
+		t.Fatalf("Addr = %q, want %q", cfg.Addr, "127.0.0.1:3306")
+	}
+	if cfg.Compress != "zstd" {
+		t.Fatalf("Compress = %q, want %q", cfg.Compress, "zstd")
+	}
+	if cfg.compressAlgo != compressZstd {
+		t.Fatalf("compressAlgo = %v, want %v", cfg.compressAlgo, compressZstd)
+	}
+}
+
+func TestParseDSNCompressInvalid(t *testing.T) {
+	if _, err := ParseDSN("127.0.0.1:3306?compress=lz4"); err == nil {
+		t.Fatalf("expected an error for an unsupported compress value")
+	}
+}
+
+func TestParseDSNNoParams(t *testing.T) {
+	cfg, err := ParseDSN("127.0.0.1:3306")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	if cfg.Addr != "127.0.0.1:3306" || cfg.compressAlgo != compressNone {
+		t.Fatalf("unexpected defaults: %+v", cfg)
+	}
+}
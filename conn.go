@@ -0,0 +1,104 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"context"
+	"net"
+)
+
+// mysqlConn is the buffer-layer half of a connection: the dialed
+// net.Conn, the buffer wrapped around it, and the Config it was built
+// from. The rest of a real connection (charset/auth state, prepared
+// statement cache, ...) lives outside this slice of the driver.
+type mysqlConn struct {
+	netConn         net.Conn
+	buf             *buffer
+	cfg             *Config
+	stopShrinkTimer func()
+}
+
+// shrinkCheckFraction sets how often startShrinkTimer polls for an idle
+// buffer relative to cfg.ShrinkIdleBuffer itself, per the "a sensible
+// default is a fraction of idleFor" guidance on startShrinkTimer.
+const shrinkCheckFraction = 4
+
+// dialFunc opens the underlying transport for addr. Passed into connect
+// explicitly rather than resolved from a registry, since the
+// network-name-keyed dial hook registry (RegisterDialContext) referenced
+// in conn_wrapper.go's docs lives outside this buffer-layer slice of the
+// driver.
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// serverCapabilities reports which compression algorithms the server
+// advertised in its handshake packet. Passed into connect explicitly
+// since parsing the handshake packet itself is the auth/protocol layer's
+// job, not the buffer layer's - connect only needs the end result to
+// decide what to negotiate.
+type serverCapabilities struct {
+	supportsZlibCompress bool
+	supportsZstdCompress bool
+}
+
+// connect dials addr, then negotiates and enables compression on the
+// resulting buffer according to cfg.Compress and what the server (via
+// caps) advertised supporting. readCaps is called once the transport is
+// up, and only when cfg requests compression, standing in for the
+// handshake packet read/parse that normally happens at this point in
+// the real connection setup.
+func connect(ctx context.Context, cfg *Config, dial dialFunc, readCaps func(net.Conn) (serverCapabilities, error)) (*mysqlConn, error) {
+	nc, err := dial(ctx, cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, tracer, err := resolveConnWrapper(nc, cfg.ConnWrapperName)
+	if err != nil {
+		return nil, err
+	}
+
+	newBuf := newBuffer(nc)
+	buf := &newBuf
+	buf.timeout = cfg.ReadTimeout
+	buf.tracer = tracer
+	buf.maxBufferSize = cfg.MaxBufferSize
+
+	if cfg.compressAlgo != compressNone {
+		caps, err := readCaps(nc)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		algo := negotiatedCompression(cfg.compressAlgo, caps.supportsZlibCompress, caps.supportsZstdCompress)
+		buf.enableCompression(algo)
+	}
+
+	stopShrinkTimer := buf.startShrinkTimer(cfg.ShrinkIdleBuffer/shrinkCheckFraction, cfg.ShrinkIdleBuffer)
+
+	return &mysqlConn{netConn: nc, buf: buf, cfg: cfg, stopShrinkTimer: stopShrinkTimer}, nil
+}
+
+// writePacket writes data to the connection, compressing it first when
+// the connection negotiated a compression algorithm during connect.
+func (mc *mysqlConn) writePacket(data []byte) error {
+	if mc.buf.compress != compressNone {
+		return mc.buf.writeCompressed(data)
+	}
+	_, err := mc.netConn.Write(data)
+	return err
+}
+
+// Close stops this connection's idle-shrink timer, if it has one, and
+// closes the underlying connection.
+func (mc *mysqlConn) Close() error {
+	if mc.stopShrinkTimer != nil {
+		mc.stopShrinkTimer()
+	}
+	return mc.netConn.Close()
+}
@@ -0,0 +1,247 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestConnectNegotiatesCompression drives connect end to end (DSN ->
+// Config -> connect) against a real net.Pipe and checks that a
+// connection whose DSN asked for compression, and whose (fake) server
+// advertised supporting it, actually ends up writing compressed frames
+// on the wire - not just that enableCompression works when called
+// directly in a test, but that a real caller reaches it via connect.
+func TestConnectNegotiatesCompression(t *testing.T) {
+	cfg, err := ParseDSN("fake-addr?compress=zstd")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	client, server := net.Pipe()
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		if addr != "fake-addr" {
+			t.Fatalf("dial got addr %q, want %q", addr, "fake-addr")
+		}
+		return client, nil
+	}
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		return serverCapabilities{supportsZlibCompress: true, supportsZstdCompress: true}, nil
+	}
+
+	mc, err := connect(context.Background(), cfg, dial, readCaps)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer mc.Close()
+
+	if mc.buf.compress != compressZstd {
+		t.Fatalf("buf.compress = %v, want %v", mc.buf.compress, compressZstd)
+	}
+
+	payload := []byte("select 1")
+	writeErr := make(chan error, 1)
+	go func() { writeErr <- mc.writePacket(payload) }()
+
+	serverBuf := newBuffer(server)
+	serverBuf.enableCompression(compressZstd)
+	got := make([]byte, len(payload))
+	if _, err := serverBuf.read(got); err != nil {
+		t.Fatalf("server read: %v", err)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("writePacket: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("server received %q, want %q", got, payload)
+	}
+}
+
+// TestConnectSkipsCompressionWhenNotRequested checks that connect never
+// calls readCaps (and never enables compression) for a DSN that didn't
+// ask for it, so a plaintext connection isn't charged any of the
+// negotiation cost.
+func TestConnectSkipsCompressionWhenNotRequested(t *testing.T) {
+	cfg, err := ParseDSN("fake-addr")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return &fakeConn{}, nil
+	}
+	readCapsCalled := false
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		readCapsCalled = true
+		return serverCapabilities{}, nil
+	}
+
+	mc, err := connect(context.Background(), cfg, dial, readCaps)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer mc.Close()
+
+	if readCapsCalled {
+		t.Fatalf("readCaps was called even though the DSN didn't request compression")
+	}
+	if mc.buf.compress != compressNone {
+		t.Fatalf("buf.compress = %v, want compressNone", mc.buf.compress)
+	}
+}
+
+// TestConnectAppliesConnWrapper checks that a DSN's connwrapper= option
+// actually reaches a registered ConnWrapper via connect, and that a
+// tracer the wrapper supplies ends up wired onto the resulting buffer -
+// not just that resolveConnWrapper works when called directly.
+func TestConnectAppliesConnWrapper(t *testing.T) {
+	const name = "test-connect-wrapper"
+
+	tracer := &countingTracer{}
+	wrapped := false
+	RegisterConnWrapper(name, func(nc net.Conn) net.Conn {
+		wrapped = true
+		return &tracedFakeConn{fakeConn: fakeConn{data: nc.(*fakeConn).data}, tracer: tracer}
+	})
+	t.Cleanup(func() { deregisterConnWrapper(name) })
+
+	cfg, err := ParseDSN("fake-addr?connwrapper=" + name)
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return &fakeConn{data: make([]byte, 16)}, nil
+	}
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		return serverCapabilities{}, nil
+	}
+
+	mc, err := connect(context.Background(), cfg, dial, readCaps)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer mc.Close()
+
+	if !wrapped {
+		t.Fatalf("connect never invoked the registered ConnWrapper")
+	}
+	if mc.buf.tracer == nil {
+		t.Fatalf("connect didn't wire the wrapper's tracer onto the buffer")
+	}
+
+	if err := mc.buf.fill(4, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if len(tracer.started) != 1 || tracer.started[0] != "fill" {
+		t.Fatalf("expected fill to be traced via the wrapper reached through connect, got %v", tracer.started)
+	}
+}
+
+// TestConnectUnknownConnWrapper checks that connect surfaces an error,
+// rather than silently dialing unwrapped, when the DSN names a
+// connwrapper that was never registered.
+func TestConnectUnknownConnWrapper(t *testing.T) {
+	cfg, err := ParseDSN("fake-addr?connwrapper=does-not-exist")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return &fakeConn{}, nil
+	}
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		return serverCapabilities{}, nil
+	}
+
+	if _, err := connect(context.Background(), cfg, dial, readCaps); err == nil {
+		t.Fatalf("expected connect to fail for an unregistered connwrapper name")
+	}
+}
+
+// TestConnectAppliesMaxBufferSize checks that a DSN's maxBufferSize=
+// option reaches the resulting buffer via connect, not just via a test
+// setting buf.maxBufferSize directly.
+func TestConnectAppliesMaxBufferSize(t *testing.T) {
+	cfg, err := ParseDSN("fake-addr?maxBufferSize=4096")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return &fakeConn{}, nil
+	}
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		return serverCapabilities{}, nil
+	}
+
+	mc, err := connect(context.Background(), cfg, dial, readCaps)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer mc.Close()
+
+	if mc.buf.maxBufferSize != 4096 {
+		t.Fatalf("buf.maxBufferSize = %d, want 4096", mc.buf.maxBufferSize)
+	}
+}
+
+// TestConnectStartsAndStopsShrinkTimer checks that a DSN's
+// shrinkIdleBuffer= option actually starts startShrinkTimer on the
+// resulting buffer (by observing it reclaim an idle buffer, the same
+// behavior TestShrinkTimerReclaimsIdleBuffer checks directly), and that
+// Close, which calls the timer's stop func, then succeeds.
+func TestConnectStartsAndStopsShrinkTimer(t *testing.T) {
+	cfg, err := ParseDSN("fake-addr?shrinkIdleBuffer=10ms")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		return &fakeConn{data: make([]byte, 16)}, nil
+	}
+	readCaps := func(net.Conn) (serverCapabilities, error) {
+		return serverCapabilities{}, nil
+	}
+
+	mc, err := connect(context.Background(), cfg, dial, readCaps)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	if err := mc.buf.fill(4, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	mc.buf.idx, mc.buf.length = 0, 0
+	if len(mc.buf.large) <= defaultBufSize {
+		t.Fatalf("test setup: fill didn't grow b.large (%d)", len(mc.buf.large))
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mc.buf.mu.Lock()
+		shrunk := len(mc.buf.large) <= defaultBufSize
+		mc.buf.mu.Unlock()
+		if shrunk {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(mc.buf.large) > defaultBufSize {
+		t.Fatalf("connect never started a shrink timer that reclaimed the idle buffer")
+	}
+
+	if err := mc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ConnWrapper wraps a freshly dialed net.Conn before the driver starts
+// speaking the MySQL protocol over it. It's the extension point for
+// observability concerns -- byte counters, read latency, packet dumps --
+// that would otherwise require patching the driver: anything the
+// returned net.Conn does in its Read/Write/SetReadDeadline methods is
+// transparently picked up by buffer, since buffer only ever calls
+// through the net.Conn interface.
+//
+// Register one with RegisterConnWrapper and select it from a DSN with
+// connwrapper=name, the same way dial functions are registered with
+// RegisterDialContext and selected via the DSN's network name.
+type ConnWrapper func(net.Conn) net.Conn
+
+var (
+	connWrappersMu sync.RWMutex
+	connWrappers   = make(map[string]ConnWrapper)
+)
+
+// RegisterConnWrapper registers a ConnWrapper under name so it can be
+// selected from a DSN with connwrapper=name.
+func RegisterConnWrapper(name string, wrapper ConnWrapper) {
+	connWrappersMu.Lock()
+	defer connWrappersMu.Unlock()
+	connWrappers[name] = wrapper
+}
+
+// deregisterConnWrapper removes a ConnWrapper registered with
+// RegisterConnWrapper. Exists for tests.
+func deregisterConnWrapper(name string) {
+	connWrappersMu.Lock()
+	defer connWrappersMu.Unlock()
+	delete(connWrappers, name)
+}
+
+func getConnWrapper(name string) (ConnWrapper, bool) {
+	connWrappersMu.RLock()
+	defer connWrappersMu.RUnlock()
+	wrapper, ok := connWrappers[name]
+	return wrapper, ok
+}
+
+// TracedConn can optionally be implemented by the net.Conn a ConnWrapper
+// returns, so a single wrapper can supply both byte-counting/latency
+// instrumentation (via its Read/Write/SetReadDeadline methods) and a
+// BufferTracer for fill/skip spans, instead of needing two separate
+// registration points.
+type TracedConn interface {
+	net.Conn
+	Tracer() BufferTracer
+}
+
+// resolveConnWrapper is the DSN-driven counterpart to newBuffer: given
+// the connwrapper name selected in the DSN, it looks the wrapper up and
+// applies it to nc. If the wrapped conn also implements TracedConn, the
+// tracer it supplies is returned too, ready to be assigned to a buffer's
+// tracer field right after construction:
+//
+//	nc, tracer, err := resolveConnWrapper(nc, cfg.ConnWrapperName)
+//	...
+//	buf := newBuffer(nc)
+//	buf.tracer = tracer
+//
+// wrapperName == "" is the common case of no wrapper configured, and
+// just returns nc unchanged.
+func resolveConnWrapper(nc net.Conn, wrapperName string) (net.Conn, BufferTracer, error) {
+	if wrapperName == "" {
+		return nc, nil, nil
+	}
+
+	wrap, ok := getConnWrapper(wrapperName)
+	if !ok {
+		return nil, nil, fmt.Errorf("mysql: unknown connwrapper %q", wrapperName)
+	}
+	nc = wrap(nc)
+
+	var tracer BufferTracer
+	if tc, ok := nc.(TracedConn); ok {
+		tracer = tc.Tracer()
+	}
+	return nc, tracer, nil
+}
+
+// BufferTracer receives span-like callbacks around buffer operations
+// that touch the network (fill, skip), for diagnosing slow queries
+// where the bottleneck is the socket rather than the server. Start is
+// called before the operation begins; the func it returns is called
+// once the operation completes, with the error it finished with (nil on
+// success). Implementations can adapt this directly onto OpenTelemetry
+// spans: start a span in Start, End() it in the returned func.
+type BufferTracer interface {
+	Start(op string) func(err error)
+}
+
+// traceOp runs Start/end around fn if a tracer is registered, otherwise
+// it just calls fn.
+func (b *buffer) traceOp(op string, fn func() error) error {
+	if b.tracer == nil {
+		return fn()
+	}
+	end := b.tracer.Start(op)
+	err := fn()
+	end(err)
+	return err
+}
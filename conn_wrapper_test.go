@@ -0,0 +1,115 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"net"
+	"testing"
+)
+
+// countingTracer records how many ops were started and how many
+// completed, and with which error, so tests can check traceOp actually
+// wraps fill/skip rather than being a permanent no-op.
+type countingTracer struct {
+	started   []string
+	completed []error
+}
+
+func (c *countingTracer) Start(op string) func(error) {
+	c.started = append(c.started, op)
+	return func(err error) {
+		c.completed = append(c.completed, err)
+	}
+}
+
+// tracedFakeConn is a fakeConn whose wrapper also supplies a BufferTracer,
+// exercising the TracedConn path of resolveConnWrapper.
+type tracedFakeConn struct {
+	fakeConn
+	tracer BufferTracer
+}
+
+func (c *tracedFakeConn) Tracer() BufferTracer { return c.tracer }
+
+func TestRegisterConnWrapperRoundTrip(t *testing.T) {
+	const name = "test-wrapper-round-trip"
+
+	if _, ok := getConnWrapper(name); ok {
+		t.Fatalf("getConnWrapper(%q) found a wrapper before any was registered", name)
+	}
+
+	called := false
+	RegisterConnWrapper(name, func(nc net.Conn) net.Conn {
+		called = true
+		return nc
+	})
+	t.Cleanup(func() { deregisterConnWrapper(name) })
+
+	wrap, ok := getConnWrapper(name)
+	if !ok {
+		t.Fatalf("getConnWrapper(%q) found nothing after RegisterConnWrapper", name)
+	}
+	wrap(&fakeConn{})
+	if !called {
+		t.Fatalf("registered wrapper was never invoked")
+	}
+
+	deregisterConnWrapper(name)
+	if _, ok := getConnWrapper(name); ok {
+		t.Fatalf("getConnWrapper(%q) still found a wrapper after deregisterConnWrapper", name)
+	}
+}
+
+func TestResolveConnWrapperThreadsTracer(t *testing.T) {
+	const name = "test-wrapper-tracer"
+
+	tracer := &countingTracer{}
+	RegisterConnWrapper(name, func(nc net.Conn) net.Conn {
+		return &tracedFakeConn{fakeConn: fakeConn{data: nc.(*fakeConn).data}, tracer: tracer}
+	})
+	t.Cleanup(func() { deregisterConnWrapper(name) })
+
+	nc, gotTracer, err := resolveConnWrapper(&fakeConn{data: make([]byte, 16)}, name)
+	if err != nil {
+		t.Fatalf("resolveConnWrapper: %v", err)
+	}
+	if gotTracer == nil {
+		t.Fatalf("expected the TracedConn's tracer to be returned by resolveConnWrapper")
+	}
+
+	b := newBuffer(nc)
+	b.tracer = gotTracer
+
+	if err := b.fill(4, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if len(tracer.started) != 1 || tracer.started[0] != "fill" || tracer.completed[0] != nil {
+		t.Fatalf("expected fill to be traced and complete cleanly, got started=%v completed=%v", tracer.started, tracer.completed)
+	}
+
+	// Drain whatever fill buffered, then force a skip that can't be
+	// satisfied from the buffer and has to fall back to the network -
+	// that's the path that actually exercises skip's traceOp wrapper.
+	b.idx += b.length
+	b.length = 0
+	b.skip(1) // the fake conn has nothing left, so this errors; we only care that it's traced
+
+	if len(tracer.started) != 2 || tracer.started[1] != "skip" {
+		t.Fatalf("expected fill then skip to be traced, got %v", tracer.started)
+	}
+	if len(tracer.completed) != 2 {
+		t.Fatalf("expected both traced ops to complete, got %d completions", len(tracer.completed))
+	}
+}
+
+func TestResolveConnWrapperUnknownName(t *testing.T) {
+	if _, _, err := resolveConnWrapper(&fakeConn{}, "does-not-exist"); err == nil {
+		t.Fatalf("expected an error for an unregistered connwrapper name")
+	}
+}
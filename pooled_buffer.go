@@ -0,0 +1,109 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"sync"
+	"time"
+)
+
+// largeBufferPool holds oversized scratch buffers that were borrowed to
+// read a single large packet on a connection with MaxBufferSize set,
+// rather than being retained forever as that connection's b.large. This
+// keeps one connection's occasional big BLOB read from permanently
+// inflating the whole pool's RSS.
+var largeBufferPool = sync.Pool{
+	New: func() interface{} {
+		return make([]byte, 0)
+	},
+}
+
+func getPooledBuffer(n int) []byte {
+	buf := largeBufferPool.Get().([]byte)
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+func putPooledBuffer(buf []byte) {
+	largeBufferPool.Put(buf[:0]) //nolint:staticcheck // intentionally retain capacity
+}
+
+// releasePooled returns b.buf to largeBufferPool if it was borrowed
+// rather than owned by the connection, and clears the pooled flag. It
+// must be called before b.buf is reassigned to anything else.
+func (b *buffer) releasePooled() {
+	if b.bufPooled {
+		putPooledBuffer(b.buf)
+		b.bufPooled = false
+	}
+}
+
+// shrinkIdle resets b.large back to defaultBufSize if it has grown
+// past it and the connection has been idle for at least idleFor. It's
+// driven by startShrinkTimer, so a connection that once read a big
+// result set doesn't hold onto that memory indefinitely while sitting
+// idle in the pool.
+func (b *buffer) shrinkIdle(idleFor time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.large) <= defaultBufSize {
+		return
+	}
+	if b.length > 0 {
+		// a packet is still live in the buffer; don't pull the rug out
+		return
+	}
+	if now.Sub(b.lastUsed) < idleFor {
+		return
+	}
+
+	shrunk := make([]byte, defaultBufSize)
+	if !b.bufPooled && len(b.buf) == len(b.large) {
+		b.buf = shrunk
+	}
+	b.large = shrunk
+}
+
+// startShrinkTimer is the actual driver behind ShrinkIdleBuffer: it
+// periodically checks whether b.large has been idle for at least
+// idleFor and, if so, reclaims it down to defaultBufSize. checkInterval
+// controls how often that check runs; a sensible default is a fraction
+// of idleFor so the reclaim happens reasonably soon after the
+// connection goes idle without checking on every tick.
+//
+// connect starts this once per connection and mysqlConn.Close calls the
+// returned stop func. The timer keeps running while the connection is
+// in use; shrinkIdle then waits on b.mu for the current operation and
+// leaves b.large alone while a packet is live or lastUsed is recent.
+func (b *buffer) startShrinkTimer(checkInterval, idleFor time.Duration) (stop func()) {
+	if checkInterval <= 0 || idleFor <= 0 {
+		return func() {}
+	}
+
+	t := time.NewTicker(checkInterval)
+	done := make(chan struct{})
+
+	go func() {
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				b.shrinkIdle(idleFor, now)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
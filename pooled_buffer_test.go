@@ -0,0 +1,102 @@
+// Go MySQL Driver - A MySQL-Driver for Go's database/sql package
+//
+// Copyright 2013 The Go-MySQL-Driver Authors. All rights reserved.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this file,
+// You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMaxBufferSizeSmallReadsStayUnpooled exercises MaxBufferSize set to a
+// value between defaultBufSize and prefetchWindowSize, the case where
+// rounding growTo up to the next defaultBufSize multiple used to push
+// newSize back over the cap on every single fill and force the sync.Pool
+// borrow path for what should be a steady-state small-packet workload.
+func TestMaxBufferSizeSmallReadsStayUnpooled(t *testing.T) {
+	const maxBufferSize = 8000 // between defaultBufSize (4096) and prefetchWindowSize
+	const packetSize = 4
+	const packets = 50
+
+	payload := make([]byte, packetSize*packets)
+	buf := newBuffer(&fakeConn{data: payload})
+	buf.maxBufferSize = maxBufferSize
+
+	for i := 0; i < packets; i++ {
+		if buf.length < packetSize {
+			if err := buf.fill(packetSize, false); err != nil {
+				t.Fatalf("fill %d: %v", i, err)
+			}
+		}
+		if buf.bufPooled {
+			t.Fatalf("fill %d: fell through to the sync.Pool borrow path even though the request fits under MaxBufferSize", i)
+		}
+		buf.idx += packetSize
+		buf.length -= packetSize
+	}
+
+	if len(buf.large) > maxBufferSize {
+		t.Fatalf("b.large grew to %d, want <= MaxBufferSize (%d)", len(buf.large), maxBufferSize)
+	}
+}
+
+// TestMaxBufferSizeOverCapStillBorrowsFromPool checks that a packet
+// genuinely larger than MaxBufferSize still takes the pool-borrow path
+// instead of growing b.large past the configured cap.
+func TestMaxBufferSizeOverCapStillBorrowsFromPool(t *testing.T) {
+	const maxBufferSize = 4096
+	const need = maxBufferSize * 4
+
+	buf := newBuffer(&fakeConn{data: make([]byte, need)})
+	buf.maxBufferSize = maxBufferSize
+
+	if err := buf.fill(need, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	if !buf.bufPooled {
+		t.Fatalf("expected a fill larger than MaxBufferSize to borrow from the pool")
+	}
+	if len(buf.large) > maxBufferSize {
+		t.Fatalf("b.large grew to %d past MaxBufferSize (%d)", len(buf.large), maxBufferSize)
+	}
+}
+
+// TestShrinkTimerReclaimsIdleBuffer checks that startShrinkTimer actually
+// drives shrinkIdle: once the buffer has grown past defaultBufSize and
+// then sat idle for longer than idleFor, the timer reclaims it without
+// any caller explicitly invoking shrinkIdle.
+func TestShrinkTimerReclaimsIdleBuffer(t *testing.T) {
+	// A small-packet fill triggers fillLocked's prefetch-window growth,
+	// which (unlike a plain oversized need) is the path that persists
+	// the wider buffer into b.large; see the fillLocked comment on
+	// growTo != need.
+	buf := newBuffer(&fakeConn{data: make([]byte, 16)})
+	if err := buf.fill(4, false); err != nil {
+		t.Fatalf("fill: %v", err)
+	}
+	buf.idx = 0
+	buf.length = 0 // simulate the packet having been fully consumed
+	if len(buf.large) <= defaultBufSize {
+		t.Fatalf("test setup: fill didn't grow b.large (%d)", len(buf.large))
+	}
+
+	stop := buf.startShrinkTimer(5*time.Millisecond, 10*time.Millisecond)
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		buf.mu.Lock()
+		shrunk := len(buf.large) <= defaultBufSize
+		buf.mu.Unlock()
+		if shrunk {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("startShrinkTimer never reclaimed the idle buffer")
+}